@@ -0,0 +1,189 @@
+package imapsrv
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Authenticator validates a set of plaintext credentials. Mailstores
+// implement this and set it as Config.Authenticator; while it is nil, every
+// LOGIN and AUTHENTICATE is rejected. It is used by both LOGIN and the SASL
+// mechanisms registered on Config.
+type Authenticator interface {
+	// Authenticate returns true if userId/password are a valid pair
+	Authenticate(userId string, password string) (bool, error)
+}
+
+// SaslMechanism is a stateless SASL mechanism factory (RFC 4422), registered
+// once on Config and shared by every session. Because AUTHENTICATE
+// exchanges run concurrently across sessions, a mechanism must not hold any
+// per-exchange state itself - NewExchange hands each AUTHENTICATE command a
+// fresh SaslExchange to hold that.
+type SaslMechanism interface {
+	// NewExchange returns a new SaslExchange to drive a single
+	// AUTHENTICATE command's exchange.
+	NewExchange() SaslExchange
+}
+
+// SaslExchange drives a single SASL exchange (RFC 4422) for one
+// AUTHENTICATE command: Start is called first, and Next is called with the
+// client's response to each challenge returned. A nil challenge with
+// done == true means authentication succeeded. A SaslExchange is used by
+// exactly one AUTHENTICATE command and then discarded.
+type SaslExchange interface {
+	// Start begins the exchange. If the client supplied an initial
+	// response (SASL-IR), it is passed in initialResponse; otherwise
+	// initialResponse is nil and the mechanism should return its first
+	// challenge.
+	Start(auth Authenticator, initialResponse []byte) (challenge []byte, done bool, err error)
+	// Next continues the exchange with the client's decoded response to
+	// the last challenge.
+	Next(response []byte) (challenge []byte, done bool, err error)
+}
+
+// errAuthFailed is returned by a mechanism's Next/Start when the supplied
+// credentials are rejected
+var errAuthFailed = errors.New("authentication failed")
+
+//------------------------------------------------------------------------------
+
+// PlainMechanism implements the PLAIN SASL mechanism (RFC 4616): a single
+// response of the form "authzid\0authcid\0password".
+type PlainMechanism struct{}
+
+// NewPlainMechanism creates a PLAIN SASL mechanism
+func NewPlainMechanism() *PlainMechanism {
+	return &PlainMechanism{}
+}
+
+// NewExchange returns a new exchange for a single PLAIN AUTHENTICATE command
+func (m *PlainMechanism) NewExchange() SaslExchange {
+	return &plainExchange{}
+}
+
+// plainExchange holds the per-exchange state for a single PLAIN
+// AUTHENTICATE command.
+type plainExchange struct {
+	auth Authenticator
+}
+
+// Start begins a PLAIN exchange. PLAIN supports an initial response, so the
+// whole exchange can complete in Start.
+func (e *plainExchange) Start(auth Authenticator, initialResponse []byte) ([]byte, bool, error) {
+	e.auth = auth
+
+	if initialResponse == nil {
+		// No initial response - ask the client for one with an empty challenge
+		return []byte{}, false, nil
+	}
+
+	return e.Next(initialResponse)
+}
+
+// Next validates the "authzid\0authcid\0password" response
+func (e *plainExchange) Next(response []byte) ([]byte, bool, error) {
+	parts := strings.SplitN(string(response), "\x00", 3)
+	if len(parts) != 3 {
+		return nil, false, errors.New("PLAIN malformed response")
+	}
+
+	userId, password := parts[1], parts[2]
+
+	ok, err := e.auth.Authenticate(userId, password)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, errAuthFailed
+	}
+
+	return nil, true, nil
+}
+
+//------------------------------------------------------------------------------
+
+// CramMD5Mechanism implements the CRAM-MD5 SASL mechanism (RFC 2195): the
+// server sends a challenge string, the client replies with
+// "userid hex(hmac-md5(password, challenge))".
+type CramMD5Mechanism struct{}
+
+// NewCramMD5Mechanism creates a CRAM-MD5 SASL mechanism
+func NewCramMD5Mechanism() *CramMD5Mechanism {
+	return &CramMD5Mechanism{}
+}
+
+// NewExchange returns a new exchange for a single CRAM-MD5 AUTHENTICATE
+// command
+func (m *CramMD5Mechanism) NewExchange() SaslExchange {
+	return &cramMD5Exchange{}
+}
+
+// cramMD5Exchange holds the per-exchange state (the issued challenge) for a
+// single CRAM-MD5 AUTHENTICATE command.
+type cramMD5Exchange struct {
+	auth      Authenticator
+	challenge string
+}
+
+// Start issues the CRAM-MD5 challenge. CRAM-MD5 has no meaningful initial
+// response since the client needs the server's challenge first.
+func (e *cramMD5Exchange) Start(auth Authenticator, initialResponse []byte) ([]byte, bool, error) {
+	e.auth = auth
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, false, err
+	}
+
+	e.challenge = fmt.Sprintf("<%x@imapsrv>", nonce)
+	return []byte(e.challenge), false, nil
+}
+
+// Next validates the "userid digest" response against the issued challenge
+func (e *cramMD5Exchange) Next(response []byte) ([]byte, bool, error) {
+	parts := strings.SplitN(string(response), " ", 2)
+	if len(parts) != 2 {
+		return nil, false, errors.New("CRAM-MD5 malformed response")
+	}
+
+	userId, digest := parts[0], parts[1]
+
+	// The mailstore's Authenticator is expected to know the plaintext
+	// password so that the HMAC can be recomputed and compared.
+	passwordAuth, ok := e.auth.(PasswordLookupAuthenticator)
+	if !ok {
+		return nil, false, errors.New("CRAM-MD5 requires a PasswordLookupAuthenticator")
+	}
+
+	password, found, err := passwordAuth.LookupPassword(userId)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found {
+		return nil, false, errAuthFailed
+	}
+
+	mac := hmac.New(md5.New, []byte(password))
+	mac.Write([]byte(e.challenge))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if expected != digest {
+		return nil, false, errAuthFailed
+	}
+
+	return nil, true, nil
+}
+
+// PasswordLookupAuthenticator is an optional extension of Authenticator for
+// mechanisms such as CRAM-MD5 that cannot validate a hash without knowing
+// the plaintext password themselves.
+type PasswordLookupAuthenticator interface {
+	Authenticator
+	// LookupPassword returns the plaintext password for userId, if known
+	LookupPassword(userId string) (password string, found bool, err error)
+}
@@ -0,0 +1,127 @@
+package imapsrv
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// testAuthenticator is a fixed-credential Authenticator/PasswordLookupAuthenticator
+// for exercising the SASL mechanisms in isolation.
+type testAuthenticator struct {
+	userId   string
+	password string
+}
+
+func (a *testAuthenticator) Authenticate(userId string, password string) (bool, error) {
+	return userId == a.userId && password == a.password, nil
+}
+
+func (a *testAuthenticator) LookupPassword(userId string) (string, bool, error) {
+	if userId != a.userId {
+		return "", false, nil
+	}
+	return a.password, true, nil
+}
+
+func TestPlainMechanism(t *testing.T) {
+	auth := &testAuthenticator{userId: "fred", password: "hunter2"}
+	mechanism := NewPlainMechanism()
+
+	exchange := mechanism.NewExchange()
+	_, done, err := exchange.Start(auth, []byte("\x00fred\x00hunter2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !done {
+		t.Error("expected the exchange to complete with an initial response")
+	}
+}
+
+func TestPlainMechanismBadPassword(t *testing.T) {
+	auth := &testAuthenticator{userId: "fred", password: "hunter2"}
+	mechanism := NewPlainMechanism()
+
+	exchange := mechanism.NewExchange()
+	_, _, err := exchange.Start(auth, []byte("\x00fred\x00wrong"))
+	if !errors.Is(err, errAuthFailed) {
+		t.Errorf("expected errAuthFailed, got %v", err)
+	}
+}
+
+func TestCramMD5Mechanism(t *testing.T) {
+	auth := &testAuthenticator{userId: "fred", password: "hunter2"}
+	mechanism := NewCramMD5Mechanism()
+
+	exchange := mechanism.NewExchange()
+	challenge, done, err := exchange.Start(auth, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if done {
+		t.Fatal("expected the exchange to issue a challenge first")
+	}
+
+	mac := hmac.New(md5.New, []byte(auth.password))
+	mac.Write(challenge)
+	digest := hex.EncodeToString(mac.Sum(nil))
+
+	_, done, err = exchange.Next([]byte("fred " + digest))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !done {
+		t.Error("expected the exchange to complete after a valid digest")
+	}
+}
+
+// TestCramMD5MechanismConcurrentExchanges is a regression test for a bug
+// where the challenge was stored on the shared, registered CramMD5Mechanism
+// instance: two concurrent exchanges would overwrite each other's challenge,
+// so a slower client's digest would be validated against a different
+// client's nonce. Each exchange now holds its own state.
+func TestCramMD5MechanismConcurrentExchanges(t *testing.T) {
+	mechanism := NewCramMD5Mechanism()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+
+	for i := 0; i < 50; i++ {
+		auth := &testAuthenticator{userId: "fred", password: "hunter2"}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			exchange := mechanism.NewExchange()
+			challenge, _, err := exchange.Start(auth, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			mac := hmac.New(md5.New, []byte(auth.password))
+			mac.Write(challenge)
+			digest := hex.EncodeToString(mac.Sum(nil))
+
+			_, done, err := exchange.Next([]byte("fred " + digest))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !done {
+				errs <- errors.New("exchange did not complete")
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -1,8 +1,11 @@
 package imapsrv
 
 import (
+	"encoding/base64"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // An IMAP command
@@ -37,9 +40,23 @@ type capability struct {
 // Execute a capability
 func (c *capability) execute(s *session) *response {
 	// The IMAP server is assumed to be running over SSL and so
-	// STARTTLS is not supported and LOGIN is not disabled
+	// STARTTLS is not supported
+	caps := "CAPABILITY IMAP4rev1 SASL-IR IDLE LIST-EXTENDED CHILDREN SPECIAL-USE NAMESPACE"
+
+	for _, name := range s.config.MechanismNames() {
+		caps += " AUTH=" + name
+	}
+
+	if s.config.LoginDisabled {
+		caps += " LOGINDISABLED"
+	}
+
+	if s.config.UTF8Accept {
+		caps += " UTF8=ACCEPT"
+	}
+
 	return ok(c.tag, "CAPABILITY completed").
-		extra("CAPABILITY IMAP4rev1")
+		extra(caps)
 }
 
 //------------------------------------------------------------------------------
@@ -61,14 +78,106 @@ func (c *login) execute(sess *session) *response {
 		return bad(c.tag, message)
 	}
 
-	// TODO: implement login
-	if c.userId == "test" {
-		sess.st = authenticated
-		return ok(c.tag, "LOGIN completed")
+	if sess.config.LoginDisabled {
+		return bad(c.tag, "LOGIN disabled, use AUTHENTICATE")
 	}
 
-	// Fail by default
-	return no(c.tag, "LOGIN failure")
+	if sess.config.Authenticator == nil {
+		return no(c.tag, "LOGIN failure")
+	}
+
+	valid, err := sess.config.Authenticator.Authenticate(c.userId, c.password)
+	if err != nil {
+		return internalError(sess, c.tag, "LOGIN", err)
+	}
+
+	if !valid {
+		return no(c.tag, "LOGIN failure")
+	}
+
+	sess.st = authenticated
+	return ok(c.tag, "LOGIN completed")
+}
+
+//------------------------------------------------------------------------------
+
+// An AUTHENTICATE command
+type authenticate struct {
+	tag             string
+	mechanismName   string
+	initialResponse string // base64, empty if not supplied (no SASL-IR)
+	hasInitial      bool
+}
+
+// Execute an authenticate command. This drives a SaslMechanism through its
+// continuation exchange, reading further lines directly off the client
+// connection until the mechanism reports it is done or the client cancels
+// with "*".
+func (c *authenticate) execute(sess *session) *response {
+
+	// Has the user already logged in?
+	if sess.st != notAuthenticated {
+		message := "AUTHENTICATE already logged in"
+		sess.log(message)
+		return bad(c.tag, message)
+	}
+
+	mechanism, found := sess.config.Mechanism(c.mechanismName)
+	if !found {
+		return no(c.tag, "AUTHENTICATE unsupported mechanism")
+	}
+
+	if sess.config.Authenticator == nil {
+		return no(c.tag, "AUTHENTICATE failure")
+	}
+
+	var initial []byte
+	if c.hasInitial {
+		decoded, err := decodeContinuation(c.initialResponse)
+		if err != nil {
+			return bad(c.tag, "AUTHENTICATE invalid initial response")
+		}
+		initial = decoded
+	}
+
+	// Each AUTHENTICATE command gets its own exchange so that concurrent
+	// sessions authenticating with the same mechanism don't share state.
+	exchange := mechanism.NewExchange()
+
+	challenge, done, err := exchange.Start(sess.config.Authenticator, initial)
+	if err != nil {
+		return no(c.tag, "AUTHENTICATE failure")
+	}
+
+	for !done {
+		line, err := sess.sendContinuation(base64.StdEncoding.EncodeToString(challenge))
+		if err != nil {
+			return internalError(sess, c.tag, "AUTHENTICATE", err)
+		}
+
+		// The client may cancel the exchange with a lone "*"
+		if line == "*" {
+			return bad(c.tag, "AUTHENTICATE cancelled")
+		}
+
+		response, err := decodeContinuation(line)
+		if err != nil {
+			return bad(c.tag, "AUTHENTICATE invalid response")
+		}
+
+		challenge, done, err = exchange.Next(response)
+		if err != nil {
+			return no(c.tag, "AUTHENTICATE failure")
+		}
+	}
+
+	sess.st = authenticated
+	return ok(c.tag, "AUTHENTICATE completed")
+}
+
+// decodeContinuation decodes a base64 continuation line from the client
+func decodeContinuation(line string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(line)
 }
 
 //------------------------------------------------------------------------------
@@ -103,8 +212,13 @@ func (c *selectMailbox) execute(sess *session) *response {
 		return mustAuthenticate(sess, c.tag, "SELECT")
 	}
 
+	mailbox, err := sess.decodeMailboxName(c.mailbox)
+	if err != nil {
+		return bad(c.tag, "SELECT "+err.Error())
+	}
+
 	// Select the mailbox
-	exists, err := sess.selectMailbox(c.mailbox)
+	exists, err := sess.selectMailbox(mailbox)
 
 	if err != nil {
 		return internalError(sess, c.tag, "SELECT", err)
@@ -128,44 +242,105 @@ func (c *selectMailbox) execute(sess *session) *response {
 
 //------------------------------------------------------------------------------
 
-// A LIST command
+// A LIST command, extended with the RFC 5258 LIST-EXTENDED selection
+// options "(SUBSCRIBED RECURSIVEMATCH REMOTE)" and return options
+// "(CHILDREN SUBSCRIBED SPECIAL-USE)", plus support for multiple mailbox
+// patterns in one command.
 type list struct {
-	tag         string
-	reference   string // Context of mailbox name
-	mboxPattern string // The mailbox name pattern
+	tag          string
+	reference    string   // Context of mailbox name
+	mboxPatterns []string // One or more mailbox name patterns
+
+	selectSubscribed     bool
+	selectRecursiveMatch bool
+	selectRemote         bool
+
+	returnChildren   bool
+	returnSubscribed bool
+	returnSpecialUse bool
 }
 
 // List command
-// TODO: convert path to a slice
 func (c *list) execute(sess *session) *response {
 
 	// Is the user authenticated?
-	if sess.st != authenticated {
+	if sess.st != authenticated && sess.st != selected {
 		return mustAuthenticate(sess, c.tag, "LIST")
 	}
 
-	// Is the mailbox pattern empty? This indicates that we should return
-	// the delimiter and the root name of the reference
-	if c.mboxPattern == "" {
+	// RECURSIVEMATCH is only accepted alongside the SUBSCRIBED selection option
+	if c.selectRecursiveMatch && !c.selectSubscribed {
+		return bad(c.tag, "LIST RECURSIVEMATCH requires SUBSCRIBED")
+	}
+
+	reference, err := sess.decodeMailboxName(c.reference)
+	if err != nil {
+		return bad(c.tag, "LIST "+err.Error())
+	}
+
+	// A single empty pattern indicates that we should return the
+	// delimiter and the root name of the reference
+	if len(c.mboxPatterns) == 1 && c.mboxPatterns[0] == "" {
 		res := ok(c.tag, "LIST completed")
-		res.extra(fmt.Sprintf(`LIST () "%s" %s`, pathDelimiter, c.reference))
+		res.extra(fmt.Sprintf(`LIST () "%s" %s`, string(pathDelimiter), sess.encodeMailboxName(reference)))
 		return res
 	}
 
 	// Add a trailing delimiter to the reference
-	c.reference = addTrailingDelimiter(c.reference)
+	reference = addTrailingDelimiter(reference)
 
-	// Remove leading and trailing delimiters from the mboxPattern so that
-	// the session functions can assume a canonical form
-	c.mboxPattern = removeDelimiters(c.mboxPattern)
+	// Gather matches for every pattern, in order, without duplicates
+	seen := make(map[uint32]bool)
+	mboxes := make([]*Mailbox, 0, 8)
 
-	// Get the list of mailboxes
-	mboxes, err := sess.list(c.reference, c.mboxPattern)
+	for _, rawPattern := range c.mboxPatterns {
+		pattern, err := sess.decodeMailboxName(rawPattern)
+		if err != nil {
+			return bad(c.tag, "LIST "+err.Error())
+		}
 
-	if err != nil {
+		// Remove leading and trailing delimiters so that the session
+		// functions can assume a canonical form
+		pattern = removeDelimiters(pattern)
+
+		matches, err := sess.list(reference, pattern)
+		if err != nil {
+			return internalError(sess, c.tag, "LIST", err)
+		}
+
+		for _, mbox := range matches {
+			if seen[mbox.Id] {
+				continue
+			}
+			seen[mbox.Id] = true
+			mboxes = append(mboxes, mbox)
+		}
+	}
+
+	// Annotate with whatever the selection/return options asked for
+	withSubscribed := c.selectSubscribed || c.returnSubscribed
+	if err := sess.annotateListResults(mboxes, c.returnChildren, withSubscribed, c.returnSpecialUse); err != nil {
 		return internalError(sess, c.tag, "LIST", err)
 	}
 
+	if c.selectSubscribed {
+		if c.selectRecursiveMatch {
+			filtered, err := sess.filterRecursiveMatch(mboxes)
+			if err != nil {
+				return internalError(sess, c.tag, "LIST", err)
+			}
+			mboxes = filtered
+		} else {
+			filtered := mboxes[:0]
+			for _, mbox := range mboxes {
+				if mbox.Flags&Subscribed != 0 {
+					filtered = append(filtered, mbox)
+				}
+			}
+			mboxes = filtered
+		}
+	}
+
 	// Check for an empty response
 	if len(mboxes) == 0 {
 		return no(c.tag, "LIST no results")
@@ -175,7 +350,72 @@ func (c *list) execute(sess *session) *response {
 	res := ok(c.tag, "LIST completed")
 	for _, mbox := range mboxes {
 		res.extra(fmt.Sprintf(`LIST (%s) "%s" %s`,
-			joinMailboxFlags(mbox), pathDelimiter, mbox.Path))
+			joinMailboxFlags(mbox), string(pathDelimiter), sess.encodeMailboxName(mbox.Path)))
+	}
+
+	return res
+}
+
+//------------------------------------------------------------------------------
+
+// An LSUB command - like LIST, but restricted to subscribed mailboxes
+// (RFC 3501 §6.3.9). Kept alongside LIST-EXTENDED's SUBSCRIBED selection
+// option for clients that predate it.
+type lsub struct {
+	tag         string
+	reference   string
+	mboxPattern string
+}
+
+// Lsub command
+func (c *lsub) execute(sess *session) *response {
+
+	// Is the user authenticated?
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "LSUB")
+	}
+
+	reference, err := sess.decodeMailboxName(c.reference)
+	if err != nil {
+		return bad(c.tag, "LSUB "+err.Error())
+	}
+
+	if c.mboxPattern == "" {
+		res := ok(c.tag, "LSUB completed")
+		res.extra(fmt.Sprintf(`LSUB () "%s" %s`, string(pathDelimiter), sess.encodeMailboxName(reference)))
+		return res
+	}
+
+	pattern, err := sess.decodeMailboxName(c.mboxPattern)
+	if err != nil {
+		return bad(c.tag, "LSUB "+err.Error())
+	}
+
+	reference = addTrailingDelimiter(reference)
+	pattern = removeDelimiters(pattern)
+
+	mboxes, err := sess.list(reference, pattern)
+	if err != nil {
+		return internalError(sess, c.tag, "LSUB", err)
+	}
+
+	if err := sess.annotateListResults(mboxes, false, true, false); err != nil {
+		return internalError(sess, c.tag, "LSUB", err)
+	}
+
+	res := ok(c.tag, "LSUB completed")
+	found := false
+	for _, mbox := range mboxes {
+		if mbox.Flags&Subscribed == 0 {
+			continue
+		}
+		found = true
+		res.extra(fmt.Sprintf(`LSUB (%s) "%s" %s`,
+			joinMailboxFlags(mbox), string(pathDelimiter), sess.encodeMailboxName(mbox.Path)))
+	}
+
+	if !found {
+		return no(c.tag, "LSUB no results")
 	}
 
 	return res
@@ -183,6 +423,600 @@ func (c *list) execute(sess *session) *response {
 
 //------------------------------------------------------------------------------
 
+// An IDLE command
+type idle struct {
+	tag string
+}
+
+// Execute an idle command. The session must already have a mailbox
+// selected: the server streams that mailbox's Updates as untagged
+// responses until the client ends the idle with "DONE". Unlike every other
+// command, IDLE can't wait for the client's next line and stream mailbox
+// updates at the same time on one goroutine, so it reads the connection
+// itself in the background via sess.awaitIdleDone, forwarding "DONE" to the
+// select loop below through sess.idleDone rather than trying to parse it as an ordinary tagged
+// command. The stop channel, closed on return, tells that background
+// goroutine to give up reading so it doesn't steal lines meant for the
+// next command once IDLE is over.
+func (c *idle) execute(sess *session) *response {
+
+	if sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "IDLE")
+	}
+
+	done := make(chan struct{}, 1)
+	sess.idleDone = done
+	defer func() { sess.idleDone = nil }()
+
+	if err := sess.writeLine("+ idling"); err != nil {
+		return internalError(sess, c.tag, "IDLE", err)
+	}
+
+	stop := make(chan struct{})
+	readerStopped := make(chan struct{})
+	go func() {
+		defer close(readerStopped)
+		sess.awaitIdleDone(stop)
+	}()
+	// Wait for awaitIdleDone to actually stop reading before returning, not
+	// just tell it to: otherwise it could still be mid-read when the next
+	// command tries to read its own line off the same connection.
+	defer func() {
+		close(stop)
+		<-readerStopped
+		sess.conn.SetReadDeadline(time.Time{})
+	}()
+
+	updates := sess.mailbox.Updates
+
+	for {
+		select {
+		case <-done:
+			return ok(c.tag, "IDLE terminated")
+
+		case update, isOpen := <-updates:
+			if !isOpen {
+				return ok(c.tag, "IDLE terminated")
+			}
+			if err := sess.writeLine("* " + update.String()); err != nil {
+				return internalError(sess, c.tag, "IDLE", err)
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// An APPEND command (RFC 3501 §6.3.11)
+type appendMessage struct {
+	tag          string
+	mailbox      string
+	flags        MessageFlags
+	internalDate time.Time
+	hasDate      bool
+	literal      []byte
+}
+
+// Execute an append command
+func (c *appendMessage) execute(sess *session) *response {
+
+	// APPEND does not require a mailbox to be selected
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "APPEND")
+	}
+
+	mbox, err := sess.config.Mailstore.GetMailbox(c.mailbox)
+	if err != nil {
+		return internalError(sess, c.tag, "APPEND", err)
+	}
+	if mbox == nil {
+		return no(c.tag, "APPEND [TRYCREATE] No such mailbox")
+	}
+
+	internalDate := c.internalDate
+	if !c.hasDate {
+		internalDate = time.Now()
+	}
+
+	if _, err := sess.config.Mailstore.AppendMessage(c.mailbox, c.literal, c.flags, internalDate); err != nil {
+		return internalError(sess, c.tag, "APPEND", err)
+	}
+
+	return ok(c.tag, "APPEND completed")
+}
+
+//------------------------------------------------------------------------------
+
+// FetchAttrName identifies a single RFC 3501 §6.4.5 FETCH data item.
+type FetchAttrName int
+
+const (
+	FetchFlags FetchAttrName = iota
+	FetchUid
+	FetchInternalDate
+	FetchRfc822Size
+	FetchEnvelope
+	FetchBodyStructure
+	// FetchBody is "BODY[section]" / "BODY.PEEK[section]", optionally with
+	// a "<offset.length>" partial range. See FetchAttr.
+	FetchBody
+)
+
+// FetchAttr is a single data item requested by a FETCH command. Section,
+// Peek and the partial-range fields only apply to FetchBody.
+type FetchAttr struct {
+	Name FetchAttrName
+
+	// Section is the BODY[] section, e.g. "", "HEADER", "TEXT", "1.MIME"
+	Section string
+	// Peek marks BODY.PEEK[] rather than BODY[]. Neither form changes the
+	// message's flags here: a BODY[] fetch does not set \Seen either.
+	Peek bool
+
+	// Partial marks a "<offset.length>" range on the section
+	Partial bool
+	Offset  int64
+	Length  int64
+}
+
+// A FETCH command
+type fetch struct {
+	tag   string
+	set   string
+	byUid bool
+	attrs []FetchAttr
+}
+
+// Execute a fetch command
+func (c *fetch) execute(sess *session) *response {
+
+	if sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "FETCH")
+	}
+
+	set, err := ParseSeqSet(c.set)
+	if err != nil {
+		return bad(c.tag, "FETCH "+err.Error())
+	}
+
+	messages, err := sess.config.Mailstore.Messages(sess.mailbox.Id, set, c.byUid)
+	if err != nil {
+		return internalError(sess, c.tag, "FETCH", err)
+	}
+
+	// RFC 3501 §6.4.8: a UID FETCH must return the UID data item for each
+	// message even if the client didn't ask for it
+	attrs := c.attrs
+	if c.byUid && !hasFetchAttr(attrs, FetchUid) {
+		attrs = append(attrs, FetchAttr{Name: FetchUid})
+	}
+
+	for _, msg := range messages {
+		if err := sess.writeFetchResponse(msg, attrs); err != nil {
+			return internalError(sess, c.tag, "FETCH", err)
+		}
+	}
+
+	return ok(c.tag, "FETCH completed")
+}
+
+// hasFetchAttr reports whether attrs already requests the given data item
+func hasFetchAttr(attrs []FetchAttr, name FetchAttrName) bool {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//------------------------------------------------------------------------------
+
+// A STORE command (RFC 3501 §6.4.6)
+type store struct {
+	tag    string
+	set    string
+	byUid  bool
+	mode   StoreMode
+	silent bool
+	flags  MessageFlags
+}
+
+// Execute a store command
+func (c *store) execute(sess *session) *response {
+
+	if sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "STORE")
+	}
+
+	set, err := ParseSeqSet(c.set)
+	if err != nil {
+		return bad(c.tag, "STORE "+err.Error())
+	}
+
+	messages, err := sess.config.Mailstore.StoreFlags(sess.mailbox.Id, set, c.byUid, c.mode, c.flags)
+	if err != nil {
+		return internalError(sess, c.tag, "STORE", err)
+	}
+
+	// .SILENT suppresses the untagged FETCH response for the client's own
+	// STORE, but other clients with the mailbox selected would still see
+	// it via their Updates channel
+	if !c.silent {
+		for _, msg := range messages {
+			attrs := []FetchAttr{{Name: FetchFlags}}
+			if c.byUid {
+				attrs = append(attrs, FetchAttr{Name: FetchUid})
+			}
+			if err := sess.writeFetchResponse(msg, attrs); err != nil {
+				return internalError(sess, c.tag, "STORE", err)
+			}
+		}
+	}
+
+	return ok(c.tag, "STORE completed")
+}
+
+//------------------------------------------------------------------------------
+
+// A SEARCH command (RFC 3501 §6.4.4)
+type search struct {
+	tag    string
+	byUid  bool
+	tokens []string
+}
+
+// Execute a search command
+func (c *search) execute(sess *session) *response {
+
+	if sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "SEARCH")
+	}
+
+	key, err := ParseSearchKey(c.tokens)
+	if err != nil {
+		return bad(c.tag, "SEARCH "+err.Error())
+	}
+
+	results, err := sess.config.Mailstore.Search(sess.mailbox.Id, key, c.byUid)
+	if err != nil {
+		return internalError(sess, c.tag, "SEARCH", err)
+	}
+
+	line := "SEARCH"
+	for _, n := range results {
+		line += " " + strconv.FormatUint(uint64(n), 10)
+	}
+
+	res := ok(c.tag, "SEARCH completed")
+	res.extra(line)
+	return res
+}
+
+//------------------------------------------------------------------------------
+
+// An EXPUNGE command (RFC 3501 §6.4.3)
+type expunge struct {
+	tag string
+}
+
+// Execute an expunge command
+func (c *expunge) execute(sess *session) *response {
+
+	if sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "EXPUNGE")
+	}
+
+	removed, err := sess.config.Mailstore.Expunge(sess.mailbox.Id)
+	if err != nil {
+		return internalError(sess, c.tag, "EXPUNGE", err)
+	}
+
+	// Report removals highest sequence number first, so that earlier
+	// EXPUNGE responses in this same batch don't shift the numbering of
+	// ones still to be reported
+	for i := len(removed) - 1; i >= 0; i-- {
+		update := MailboxUpdate{Kind: UpdateExpunge, SeqNum: removed[i]}
+		if err := sess.writeLine("* " + update.String()); err != nil {
+			return internalError(sess, c.tag, "EXPUNGE", err)
+		}
+	}
+
+	uids, err := sess.config.Mailstore.AllUids(sess.mailbox.Id)
+	if err != nil {
+		return internalError(sess, c.tag, "EXPUNGE", err)
+	}
+	sess.mailbox.SeqToUid = uids
+
+	return ok(c.tag, "EXPUNGE completed")
+}
+
+//------------------------------------------------------------------------------
+
+// A CHECK command (RFC 3501 §6.4.1) - acknowledged straight away as a no-op;
+// the Mailstore is not called
+type check struct {
+	tag string
+}
+
+// Execute a check command
+func (c *check) execute(sess *session) *response {
+
+	if sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "CHECK")
+	}
+
+	return ok(c.tag, "CHECK completed")
+}
+
+//------------------------------------------------------------------------------
+
+// A CLOSE command (RFC 3501 §6.4.2): expunges \Deleted messages and
+// deselects the mailbox, like EXPUNGE followed by ending the selected
+// state, but without reporting the removals as untagged EXPUNGE responses.
+type closeMailbox struct {
+	tag string
+}
+
+// Execute a close command
+func (c *closeMailbox) execute(sess *session) *response {
+
+	if sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "CLOSE")
+	}
+
+	if _, err := sess.config.Mailstore.Expunge(sess.mailbox.Id); err != nil {
+		return internalError(sess, c.tag, "CLOSE", err)
+	}
+
+	sess.mailbox = nil
+	sess.st = authenticated
+
+	return ok(c.tag, "CLOSE completed")
+}
+
+//------------------------------------------------------------------------------
+
+// A NAMESPACE command (RFC 2342): reports the personal, other-users and
+// shared namespaces configured on Config, in that order.
+type namespace struct {
+	tag string
+}
+
+// Execute a namespace command
+func (c *namespace) execute(sess *session) *response {
+
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "NAMESPACE")
+	}
+
+	res := ok(c.tag, "NAMESPACE completed")
+	res.extra(fmt.Sprintf("NAMESPACE %s %s %s",
+		namespaceList(sess.config.PersonalNamespaces),
+		namespaceList(sess.config.OtherUsersNamespaces),
+		namespaceList(sess.config.SharedNamespaces)))
+	return res
+}
+
+// namespaceList renders a NAMESPACE response's namespace list, or the atom
+// NIL if the category has no namespaces
+func namespaceList(namespaces []Namespace) string {
+	if len(namespaces) == 0 {
+		return "NIL"
+	}
+
+	entries := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		entries[i] = fmt.Sprintf(`("%s" "%s")`, ns.Prefix, string(ns.Delimiter))
+	}
+	return "(" + strings.Join(entries, "") + ")"
+}
+
+//------------------------------------------------------------------------------
+
+// StatusAttr identifies a single RFC 3501 §6.3.10 STATUS data item.
+type StatusAttr int
+
+const (
+	StatusMessages StatusAttr = iota
+	StatusRecent
+	StatusUidNext
+	StatusUidValidity
+	StatusUnseen
+)
+
+// A STATUS command (RFC 3501 §6.3.10). Unlike SELECT, this reports a
+// mailbox's information without selecting it.
+type status struct {
+	tag     string
+	mailbox string
+	attrs   []StatusAttr
+}
+
+// Execute a status command
+func (c *status) execute(sess *session) *response {
+
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "STATUS")
+	}
+
+	mailbox, err := sess.decodeMailboxName(c.mailbox)
+	if err != nil {
+		return bad(c.tag, "STATUS "+err.Error())
+	}
+
+	mbox, err := sess.config.Mailstore.GetMailbox(mailbox)
+	if err != nil {
+		return internalError(sess, c.tag, "STATUS", err)
+	}
+	if mbox == nil {
+		return no(c.tag, "STATUS no such mailbox")
+	}
+
+	line, err := sess.statusLine(mbox, c.attrs)
+	if err != nil {
+		return internalError(sess, c.tag, "STATUS", err)
+	}
+
+	res := ok(c.tag, "STATUS completed")
+	res.extra(fmt.Sprintf("STATUS %s (%s)", sess.encodeMailboxName(mbox.Path), line))
+	return res
+}
+
+//------------------------------------------------------------------------------
+
+// A CREATE command (RFC 3501 §6.3.3)
+type create struct {
+	tag     string
+	mailbox string
+}
+
+// Execute a create command
+func (c *create) execute(sess *session) *response {
+
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "CREATE")
+	}
+
+	mailbox, err := sess.decodeMailboxName(c.mailbox)
+	if err != nil {
+		return bad(c.tag, "CREATE "+err.Error())
+	}
+
+	if mailbox == "" || removeDelimiters(mailbox) == "" {
+		return bad(c.tag, "CREATE missing mailbox name")
+	}
+
+	if err := sess.config.Mailstore.CreateMailbox(mailbox); err != nil {
+		return internalError(sess, c.tag, "CREATE", err)
+	}
+
+	return ok(c.tag, "CREATE completed")
+}
+
+//------------------------------------------------------------------------------
+
+// A DELETE command (RFC 3501 §6.3.4)
+type deleteMailbox struct {
+	tag     string
+	mailbox string
+}
+
+// Execute a delete command
+func (c *deleteMailbox) execute(sess *session) *response {
+
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "DELETE")
+	}
+
+	mailbox, err := sess.decodeMailboxName(c.mailbox)
+	if err != nil {
+		return bad(c.tag, "DELETE "+err.Error())
+	}
+
+	if strings.EqualFold(mailbox, "INBOX") {
+		return no(c.tag, "DELETE cannot delete INBOX")
+	}
+
+	if err := sess.config.Mailstore.DeleteMailbox(mailbox); err != nil {
+		return internalError(sess, c.tag, "DELETE", err)
+	}
+
+	return ok(c.tag, "DELETE completed")
+}
+
+//------------------------------------------------------------------------------
+
+// A RENAME command (RFC 3501 §6.3.5)
+type rename struct {
+	tag        string
+	mailbox    string
+	newMailbox string
+}
+
+// Execute a rename command
+func (c *rename) execute(sess *session) *response {
+
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "RENAME")
+	}
+
+	from, err := sess.decodeMailboxName(c.mailbox)
+	if err != nil {
+		return bad(c.tag, "RENAME "+err.Error())
+	}
+	to, err := sess.decodeMailboxName(c.newMailbox)
+	if err != nil {
+		return bad(c.tag, "RENAME "+err.Error())
+	}
+
+	if strings.EqualFold(from, "INBOX") && strings.EqualFold(to, "INBOX") {
+		return no(c.tag, "RENAME cannot rename INBOX to itself")
+	}
+
+	if err := sess.config.Mailstore.RenameMailbox(from, to); err != nil {
+		return internalError(sess, c.tag, "RENAME", err)
+	}
+
+	return ok(c.tag, "RENAME completed")
+}
+
+//------------------------------------------------------------------------------
+
+// A SUBSCRIBE command (RFC 3501 §6.3.6)
+type subscribe struct {
+	tag     string
+	mailbox string
+}
+
+// Execute a subscribe command
+func (c *subscribe) execute(sess *session) *response {
+
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "SUBSCRIBE")
+	}
+
+	mailbox, err := sess.decodeMailboxName(c.mailbox)
+	if err != nil {
+		return bad(c.tag, "SUBSCRIBE "+err.Error())
+	}
+
+	if err := sess.config.Mailstore.Subscribe(mailbox); err != nil {
+		return internalError(sess, c.tag, "SUBSCRIBE", err)
+	}
+
+	return ok(c.tag, "SUBSCRIBE completed")
+}
+
+//------------------------------------------------------------------------------
+
+// An UNSUBSCRIBE command (RFC 3501 §6.3.7)
+type unsubscribe struct {
+	tag     string
+	mailbox string
+}
+
+// Execute an unsubscribe command
+func (c *unsubscribe) execute(sess *session) *response {
+
+	if sess.st != authenticated && sess.st != selected {
+		return mustAuthenticate(sess, c.tag, "UNSUBSCRIBE")
+	}
+
+	mailbox, err := sess.decodeMailboxName(c.mailbox)
+	if err != nil {
+		return bad(c.tag, "UNSUBSCRIBE "+err.Error())
+	}
+
+	if err := sess.config.Mailstore.Unsubscribe(mailbox); err != nil {
+		return internalError(sess, c.tag, "UNSUBSCRIBE", err)
+	}
+
+	return ok(c.tag, "UNSUBSCRIBE completed")
+}
+
+//------------------------------------------------------------------------------
+
 // An unknown/unsupported command
 type unknown struct {
 	tag string
@@ -214,6 +1048,10 @@ func mustAuthenticate(sess *session, tag string, commandName string) *response {
 
 // Add a trailing delimiter
 func addTrailingDelimiter(s string) string {
+	if s == "" {
+		return s
+	}
+
 	if s[len(s)-1] != pathDelimiter {
 		return s + string(pathDelimiter)
 	}
@@ -223,6 +1061,9 @@ func addTrailingDelimiter(s string) string {
 
 // Remove path delimiters from the start and end of a string
 func removeDelimiters(s string) string {
+	if s == "" {
+		return s
+	}
 
 	// Calculate start and end indices
 	start := 0
@@ -246,16 +1087,35 @@ func removeDelimiters(s string) string {
 
 // Return a string of mailbox flags for the given mailbox
 func joinMailboxFlags(m *Mailbox) string {
+	return flagsString(m.Flags)
+}
+
+// Convert mailbox flags into their joined wire representation
+func flagsString(flags MailboxFlags) string {
 
 	// Convert the mailbox flags into a slice of strings
-	flags := make([]string, 0, 4)
+	names := make([]string, 0, 4)
 
 	for flag, str := range mailboxFlags {
-		if m.Flags&flag != 0 {
-			flags = append(flags, str)
+		if flags&flag != 0 {
+			names = append(names, str)
 		}
 	}
 
 	// Return a joined string
-	return strings.Join(flags, ",")
+	return strings.Join(names, ",")
+}
+
+// messageFlagsString converts message flags into their joined wire
+// representation for a FETCH FLAGS response, e.g. "\Seen \Flagged"
+func messageFlagsString(flags MessageFlags) string {
+	names := make([]string, 0, 4)
+
+	for flag, str := range messageFlagNames {
+		if flags&flag != 0 {
+			names = append(names, str)
+		}
+	}
+
+	return strings.Join(names, " ")
 }
@@ -0,0 +1,79 @@
+package imapsrv
+
+// Config holds the server-wide configuration for an IMAP session: the
+// backing Mailstore, the Authenticator used to validate credentials, and
+// the SASL mechanisms available to the AUTHENTICATE command.
+type Config struct {
+	// Mailstore is the backend that stores mailboxes and messages
+	Mailstore Mailstore
+	// Authenticator validates plaintext username/password pairs. It backs
+	// both the LOGIN command and the SASL mechanisms below.
+	Authenticator Authenticator
+	// LoginDisabled causes LOGIN to be rejected and advertises
+	// LOGINDISABLED in the CAPABILITY response, forcing clients to use
+	// AUTHENTICATE instead.
+	LoginDisabled bool
+	// UTF8Accept advertises UTF8=ACCEPT (RFC 6855) and causes mailbox names
+	// to be passed through as raw UTF-8 instead of being encoded/decoded
+	// as Modified UTF-7 on the wire.
+	UTF8Accept bool
+
+	// PersonalNamespaces, OtherUsersNamespaces and SharedNamespaces are the
+	// RFC 2342 NAMESPACE entries advertised to clients. A nil slice is
+	// reported as NIL, meaning that category doesn't exist on this server.
+	PersonalNamespaces   []Namespace
+	OtherUsersNamespaces []Namespace
+	SharedNamespaces     []Namespace
+
+	// mechanisms holds the SASL mechanisms registered by name, e.g. "PLAIN"
+	mechanisms map[string]SaslMechanism
+	// mechanismNames records the order mechanisms were registered in, since
+	// mechanisms' iteration order is randomized
+	mechanismNames []string
+}
+
+// Namespace is a single RFC 2342 namespace entry: every mailbox under
+// Prefix is reached using Delimiter as its hierarchy separator. A per-user
+// personal namespace might use the prefix "~user/", for example.
+type Namespace struct {
+	Prefix    string
+	Delimiter byte
+}
+
+// NewConfig creates a Config backed by the given Mailstore with no
+// authenticator or SASL mechanisms registered, and a single personal
+// namespace rooted at the mailbox hierarchy's root.
+func NewConfig(mailstore Mailstore) *Config {
+	return &Config{
+		Mailstore:          mailstore,
+		mechanisms:         make(map[string]SaslMechanism),
+		PersonalNamespaces: []Namespace{{Prefix: "", Delimiter: pathDelimiter}},
+	}
+}
+
+// RegisterMechanism registers a SASL mechanism under the given name so that
+// it can be selected by a client's AUTHENTICATE command.
+func (c *Config) RegisterMechanism(name string, mechanism SaslMechanism) {
+	if c.mechanisms == nil {
+		c.mechanisms = make(map[string]SaslMechanism)
+	}
+	if _, exists := c.mechanisms[name]; !exists {
+		c.mechanismNames = append(c.mechanismNames, name)
+	}
+	c.mechanisms[name] = mechanism
+}
+
+// Mechanism looks up a previously registered SASL mechanism by name.
+func (c *Config) Mechanism(name string) (SaslMechanism, bool) {
+	mechanism, ok := c.mechanisms[name]
+	return mechanism, ok
+}
+
+// MechanismNames returns the names of all registered SASL mechanisms, in the
+// order they should be advertised in CAPABILITY (the order they were
+// registered in).
+func (c *Config) MechanismNames() []string {
+	names := make([]string, len(c.mechanismNames))
+	copy(names, c.mechanismNames)
+	return names
+}
@@ -0,0 +1,132 @@
+package imapsrv
+
+import "fmt"
+
+// MailboxFlags are the set of IMAP flags associated with a mailbox (e.g.
+// \Noselect, \Marked) as advertised in LIST responses.
+type MailboxFlags uint32
+
+const (
+	Noinferiors MailboxFlags = 1 << iota
+	Noselect
+	Marked
+	Unmarked
+
+	// Subscribed marks a mailbox the user has subscribed to (RFC 3501)
+	Subscribed
+	// HasChildren/HasNoChildren report child mailboxes without a further
+	// LIST round-trip, per the CHILDREN return option (RFC 5258)
+	HasChildren
+	HasNoChildren
+	// NonExistent marks a mailbox name matched only because a subscribed
+	// descendant still references it (RFC 5258 §3.1)
+	NonExistent
+
+	// Special-use flags (RFC 6154)
+	SpecialUseSent
+	SpecialUseDrafts
+	SpecialUseTrash
+	SpecialUseJunk
+	SpecialUseArchive
+	SpecialUseAll
+	SpecialUseFlagged
+)
+
+// mailboxFlags maps each flag to its wire representation
+var mailboxFlags = map[MailboxFlags]string{
+	Noinferiors:       "\\Noinferiors",
+	Noselect:          "\\Noselect",
+	Marked:            "\\Marked",
+	Unmarked:          "\\Unmarked",
+	Subscribed:        "\\Subscribed",
+	HasChildren:       "\\HasChildren",
+	HasNoChildren:     "\\HasNoChildren",
+	NonExistent:       "\\NonExistent",
+	SpecialUseSent:    "\\Sent",
+	SpecialUseDrafts:  "\\Drafts",
+	SpecialUseTrash:   "\\Trash",
+	SpecialUseJunk:    "\\Junk",
+	SpecialUseArchive: "\\Archive",
+	SpecialUseAll:     "\\All",
+	SpecialUseFlagged: "\\Flagged",
+}
+
+// Mailbox represents a single IMAP mailbox (folder)
+type Mailbox struct {
+	Id    uint32
+	Name  string
+	Path  string
+	Flags MailboxFlags
+
+	// Updates receives asynchronous EXISTS/EXPUNGE/FETCH FLAGS events from
+	// the Mailstore while a client has this mailbox selected. A session
+	// running an IDLE command streams these to the client as untagged
+	// responses until DONE is received.
+	Updates chan MailboxUpdate
+
+	// SeqToUid maps message sequence number to UID: SeqToUid[0] is the
+	// UID of message 1. It is populated by session.selectMailbox and
+	// rebuilt by the EXPUNGE command (expunge.execute).
+	SeqToUid []uint32
+}
+
+// UidForSeq returns the UID of the message at the given 1-based sequence
+// number, or 0 if there is no such message.
+func (m *Mailbox) UidForSeq(seq uint32) uint32 {
+	if seq == 0 || int(seq) > len(m.SeqToUid) {
+		return 0
+	}
+	return m.SeqToUid[seq-1]
+}
+
+// SeqForUid returns the sequence number of the message with the given UID,
+// or 0 if there is no such message.
+func (m *Mailbox) SeqForUid(uid uint32) uint32 {
+	for i, u := range m.SeqToUid {
+		if u == uid {
+			return uint32(i + 1)
+		}
+	}
+	return 0
+}
+
+//------------------------------------------------------------------------------
+
+// MailboxUpdateKind identifies the kind of asynchronous mailbox event being
+// reported to an IDLE-ing client.
+type MailboxUpdateKind int
+
+const (
+	UpdateExists MailboxUpdateKind = iota
+	UpdateExpunge
+	UpdateFetchFlags
+)
+
+// MailboxUpdate is a single asynchronous mailbox event, pushed onto a
+// Mailbox's Updates channel by the Mailstore whenever the mailbox's
+// contents change for a reason other than the idling client's own doing.
+type MailboxUpdate struct {
+	Kind MailboxUpdateKind
+	// SeqNum is the message sequence number the update applies to. Unused
+	// for UpdateExists, which reports the mailbox's new total in Count.
+	SeqNum uint32
+	// Count is the new EXISTS count, only set for UpdateExists
+	Count uint32
+	// Flags holds the message's current flags, only set for UpdateFetchFlags
+	Flags MessageFlags
+}
+
+// String renders the update as the untagged IMAP response line it
+// corresponds to.
+func (u MailboxUpdate) String() string {
+	switch u.Kind {
+	case UpdateExists:
+		return fmt.Sprintf("%d EXISTS", u.Count)
+	case UpdateExpunge:
+		return fmt.Sprintf("%d EXPUNGE", u.SeqNum)
+	case UpdateFetchFlags:
+		return fmt.Sprintf("%d FETCH (FLAGS (%s))", u.SeqNum, messageFlagsString(u.Flags))
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,75 @@
+package imapsrv
+
+import "time"
+
+// Mailstore is implemented by the storage backend that imapsrv delegates
+// mailbox and message persistence to.
+type Mailstore interface {
+	// GetMailbox gets mailbox information
+	GetMailbox(name string) (*Mailbox, error)
+	// GetMailboxes gets the mailboxes at the given path
+	GetMailboxes(path []string) ([]*Mailbox, error)
+	// FirstUnseen gets the first unseen message sequence number
+	FirstUnseen(mailboxId uint32) (int64, error)
+	// TotalMessages gets the total number of messages in the given mailbox
+	TotalMessages(mailboxId uint32) (int64, error)
+	// RecentMessages gets the number of recent messages in the given mailbox
+	RecentMessages(mailboxId uint32) (int64, error)
+	// NextUid gets the next UID that will be assigned in the given mailbox
+	NextUid(mailboxId uint32) (int64, error)
+
+	// Subscribe marks the named mailbox as subscribed for this user
+	Subscribe(name string) error
+	// Unsubscribe removes the named mailbox's subscription for this user
+	Unsubscribe(name string) error
+	// IsSubscribed reports whether the named mailbox is subscribed
+	IsSubscribed(name string) (bool, error)
+	// SubscribedDescendants returns the subscribed mailbox paths beneath
+	// path (not path itself), regardless of whether a mailbox still exists there. It
+	// backs LIST's RECURSIVEMATCH selection option (RFC 5258 §3.1), which
+	// must surface subscriptions whose mailbox has since been deleted.
+	SubscribedDescendants(path []string) ([]string, error)
+	// SpecialUse returns the special-use flag (e.g. SpecialUseSent) for the
+	// given mailbox, or 0 if it has none
+	SpecialUse(mbox *Mailbox) (MailboxFlags, error)
+
+	// CreateMailbox creates a new, empty mailbox with the given name
+	CreateMailbox(name string) error
+	// DeleteMailbox permanently removes the named mailbox and its messages
+	DeleteMailbox(name string) error
+	// RenameMailbox renames a mailbox, along with any mailboxes beneath it
+	// in the hierarchy
+	RenameMailbox(oldName string, newName string) error
+
+	// AllUids returns every message UID in the mailbox, ordered by message
+	// sequence number. It is used to build the session's seq<->UID mapping
+	// on SELECT and after EXPUNGE.
+	AllUids(mailboxId uint32) ([]uint32, error)
+	// Messages fetches the messages identified by set, which is
+	// interpreted as UIDs if byUid is true or sequence numbers otherwise
+	Messages(mailboxId uint32, set *SeqSet, byUid bool) ([]*Message, error)
+	// AppendMessage appends raw (an RFC 822 message) to the named mailbox
+	// with the given flags and internal date, and returns its new UID
+	AppendMessage(name string, raw []byte, flags MessageFlags, internalDate time.Time) (uid uint32, err error)
+	// StoreFlags applies a flag update to the messages identified by set
+	// and returns their resulting flags, in the same order as set.Expand
+	StoreFlags(mailboxId uint32, set *SeqSet, byUid bool, mode StoreMode, flags MessageFlags) ([]*Message, error)
+	// Search returns the sequence numbers (or, if byUid, the UIDs) of
+	// messages in the mailbox that satisfy criteria
+	Search(mailboxId uint32, criteria *SearchKey, byUid bool) ([]uint32, error)
+	// Expunge permanently removes every message flagged \Deleted and
+	// returns the sequence numbers that were removed, in ascending order
+	Expunge(mailboxId uint32) ([]uint32, error)
+}
+
+// StoreMode is the kind of flag update requested by STORE (RFC 3501 §6.4.6)
+type StoreMode int
+
+const (
+	// StoreReplace sets a message's flags to exactly those given (FLAGS)
+	StoreReplace StoreMode = iota
+	// StoreAdd adds the given flags to a message's existing flags (+FLAGS)
+	StoreAdd
+	// StoreRemove removes the given flags from a message (-FLAGS)
+	StoreRemove
+)
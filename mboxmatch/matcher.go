@@ -0,0 +1,66 @@
+// Package mboxmatch implements RFC 3501 mailbox-name pattern matching for
+// LIST/LSUB: '*' matches any sequence of characters, including further
+// hierarchy delimiters, '%' matches any sequence of characters that does
+// not contain the delimiter, and every other character matches literally.
+//
+// This exists as its own package because regexp.Compile on a user-supplied
+// pattern gets both of those rules wrong: regex metacharacters in mailbox
+// names (".", "+", "(") are misinterpreted unless escaped, and there is no
+// way to express "'%' must not cross the delimiter" without building the
+// escaped expression by hand anyway - at which point a small dedicated
+// matcher is clearer than a regexp one would be.
+package mboxmatch
+
+// Matcher matches mailbox names against a single pattern segment for a
+// given hierarchy delimiter.
+type Matcher struct {
+	pattern   string
+	delimiter byte
+}
+
+// New builds a Matcher for pattern against the given hierarchy delimiter.
+func New(pattern string, delimiter byte) *Matcher {
+	return &Matcher{pattern: pattern, delimiter: delimiter}
+}
+
+// Match reports whether name satisfies the pattern.
+func (m *Matcher) Match(name string) bool {
+	return match(m.pattern, name, m.delimiter)
+}
+
+// match walks pattern and name in lockstep, backtracking over '*' and '%'
+// the way a small regular-expression engine would.
+func match(pattern, name string, delim byte) bool {
+	if pattern == "" {
+		return name == ""
+	}
+
+	switch pattern[0] {
+	case '*':
+		rest := pattern[1:]
+		for i := 0; i <= len(name); i++ {
+			if match(rest, name[i:], delim) {
+				return true
+			}
+		}
+		return false
+
+	case '%':
+		rest := pattern[1:]
+		for i := 0; i <= len(name); i++ {
+			if match(rest, name[i:], delim) {
+				return true
+			}
+			if i < len(name) && name[i] == delim {
+				return false
+			}
+		}
+		return false
+
+	default:
+		if name == "" || name[0] != pattern[0] {
+			return false
+		}
+		return match(pattern[1:], name[1:], delim)
+	}
+}
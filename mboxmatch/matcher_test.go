@@ -0,0 +1,34 @@
+package mboxmatch
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	const delim = '/'
+
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"INBOX", "INBOX", true},
+		{"INBOX", "inbox", false},
+		{"*", "INBOX", true},
+		{"*", "INBOX/Sent", true},
+		{"%", "INBOX", true},
+		{"%", "INBOX/Sent", false},
+		{"INBOX/%", "INBOX/Sent", true},
+		{"INBOX/%", "INBOX/Sent/2024", false},
+		{"INBOX/*", "INBOX/Sent/2024", true},
+		{"INBOX*", "INBOX/Sent", true},
+		{"INBOX*", "INBOXES", true},
+		{"", "", true},
+		{"", "INBOX", false},
+	}
+
+	for _, c := range cases {
+		m := New(c.pattern, delim)
+		if got := m.Match(c.name); got != c.want {
+			t.Errorf("New(%q, %q).Match(%q) = %v, want %v", c.pattern, string(delim), c.name, got, c.want)
+		}
+	}
+}
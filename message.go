@@ -0,0 +1,74 @@
+package imapsrv
+
+import "time"
+
+// MessageFlags are the per-message flags defined by RFC 3501 §2.3.2
+type MessageFlags uint16
+
+const (
+	FlagSeen MessageFlags = 1 << iota
+	FlagAnswered
+	FlagFlagged
+	FlagDeleted
+	FlagDraft
+	FlagRecent
+)
+
+// messageFlagNames maps each flag to its wire representation
+var messageFlagNames = map[MessageFlags]string{
+	FlagSeen:     "\\Seen",
+	FlagAnswered: "\\Answered",
+	FlagFlagged:  "\\Flagged",
+	FlagDeleted:  "\\Deleted",
+	FlagDraft:    "\\Draft",
+	FlagRecent:   "\\Recent",
+}
+
+// Envelope is the parsed set of RFC 3501 §7.4.2 ENVELOPE fields for a
+// message, as returned by FETCH ENVELOPE.
+type Envelope struct {
+	Date      string
+	Subject   string
+	From      string
+	Sender    string
+	ReplyTo   string
+	To        string
+	Cc        string
+	Bcc       string
+	InReplyTo string
+	MessageId string
+}
+
+// BodyStructure is the parsed RFC 3501 §7.4.2 BODYSTRUCTURE for a message,
+// as returned by FETCH BODYSTRUCTURE. A multipart body lists its sub-parts
+// in Parts; a single part is summarised by type, subtype, size and lines
+// only (no body parameters, id, description or encoding).
+type BodyStructure struct {
+	MimeType    string
+	MimeSubtype string
+	Size        int64
+	Lines       int64
+	Parts       []*BodyStructure
+}
+
+// Message is a single message stored in a Mailbox, as returned by the
+// Mailstore for FETCH/STORE/SEARCH/EXPUNGE.
+type Message struct {
+	// Uid is the message's mailbox-unique, session-independent identifier
+	Uid uint32
+	// SeqNum is the message's 1-based position within the mailbox at the
+	// time it was fetched. It changes whenever earlier messages are
+	// expunged.
+	SeqNum uint32
+
+	Flags        MessageFlags
+	InternalDate time.Time
+	Size         int64
+
+	// Raw is the full RFC 822 message, used to serve BODY[]/BODY.PEEK[]
+	// fetches
+	Raw []byte
+
+	Envelope      *Envelope
+	BodyStructure *BodyStructure
+}
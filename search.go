@@ -0,0 +1,243 @@
+package imapsrv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchOp identifies the kind of RFC 3501 §6.4.4 search key a SearchKey
+// node represents.
+type SearchOp int
+
+const (
+	OpAll SearchOp = iota
+	OpAnd
+	OpOr
+	OpNot
+
+	OpFlagSet   // e.g. SEEN, ANSWERED - message has Flag set
+	OpFlagUnset // e.g. UNSEEN, UNANSWERED - message lacks Flag
+
+	OpHeader  // HEADER field value
+	OpFrom    // FROM value
+	OpTo      // TO value
+	OpCc      // CC value
+	OpBcc     // BCC value
+	OpSubject // SUBJECT value
+	OpBody    // BODY value
+	OpText    // TEXT value
+
+	OpSince  // SINCE date
+	OpBefore // BEFORE date
+	OpOn     // ON date
+
+	OpLarger  // LARGER size
+	OpSmaller // SMALLER size
+
+	OpSeqSet // a bare sequence set
+	OpUid    // UID sequence set
+)
+
+// SearchKey is a node in a parsed RFC 3501 search-key tree, as produced by
+// ParseSearchKey from the tokenized SEARCH command arguments.
+type SearchKey struct {
+	Op SearchOp
+
+	Flag     MessageFlags // OpFlagSet/OpFlagUnset
+	Field    string       // OpHeader
+	Value    string       // OpHeader/OpFrom/OpTo/OpCc/OpBcc/OpSubject/OpBody/OpText
+	Date     time.Time    // OpSince/OpBefore/OpOn
+	Size     int64        // OpLarger/OpSmaller
+	SeqSet   *SeqSet      // OpSeqSet/OpUid
+	Children []*SearchKey // OpAnd (implicit list)/OpOr (always 2)/OpNot (always 1)
+}
+
+var searchFlagKeys = map[string]MessageFlags{
+	"ANSWERED": FlagAnswered,
+	"DELETED":  FlagDeleted,
+	"DRAFT":    FlagDraft,
+	"FLAGGED":  FlagFlagged,
+	"RECENT":   FlagRecent,
+	"SEEN":     FlagSeen,
+}
+
+// ParseSearchKey parses a whitespace/quote-tokenized SEARCH argument list
+// (as produced by the command parser) into a search-key tree. Multiple
+// top-level keys are implicitly ANDed together, per RFC 3501 §6.4.4.
+func ParseSearchKey(tokens []string) (*SearchKey, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("SEARCH missing search key")
+	}
+
+	p := &searchParser{tokens: tokens}
+
+	keys := make([]*SearchKey, 0, 4)
+	for p.pos < len(p.tokens) {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 1 {
+		return keys[0], nil
+	}
+	return &SearchKey{Op: OpAnd, Children: keys}, nil
+}
+
+type searchParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *searchParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("SEARCH unexpected end of search key")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *searchParser) parseKey() (*SearchKey, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	upper := strings.ToUpper(token)
+
+	switch upper {
+	case "ALL":
+		return &SearchKey{Op: OpAll}, nil
+
+	case "NEW", "OLD":
+		// Treated as a flag test: NEW == RECENT && !SEEN, OLD == !RECENT.
+		// Approximated here via the RECENT flag; a Mailstore may refine.
+		if upper == "NEW" {
+			return &SearchKey{Op: OpFlagSet, Flag: FlagRecent}, nil
+		}
+		return &SearchKey{Op: OpFlagUnset, Flag: FlagRecent}, nil
+
+	case "NOT":
+		child, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		return &SearchKey{Op: OpNot, Children: []*SearchKey{child}}, nil
+
+	case "OR":
+		left, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		return &SearchKey{Op: OpOr, Children: []*SearchKey{left, right}}, nil
+
+	case "HEADER":
+		field, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		return &SearchKey{Op: OpHeader, Field: field, Value: value}, nil
+
+	case "FROM", "TO", "CC", "BCC", "SUBJECT", "BODY", "TEXT":
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		return &SearchKey{Op: textOpFor(upper), Value: value}, nil
+
+	case "SINCE", "BEFORE", "ON":
+		raw, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		date, err := time.Parse("2-Jan-2006", raw)
+		if err != nil {
+			return nil, fmt.Errorf("SEARCH invalid date %q", raw)
+		}
+		return &SearchKey{Op: dateOpFor(upper), Date: date}, nil
+
+	case "LARGER", "SMALLER":
+		raw, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("SEARCH invalid size %q", raw)
+		}
+		if upper == "LARGER" {
+			return &SearchKey{Op: OpLarger, Size: size}, nil
+		}
+		return &SearchKey{Op: OpSmaller, Size: size}, nil
+
+	case "UID":
+		raw, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		set, err := ParseSeqSet(raw)
+		if err != nil {
+			return nil, err
+		}
+		return &SearchKey{Op: OpUid, SeqSet: set}, nil
+
+	case "UNANSWERED", "UNDELETED", "UNDRAFT", "UNFLAGGED", "UNSEEN":
+		flag := searchFlagKeys[strings.TrimPrefix(upper, "UN")]
+		return &SearchKey{Op: OpFlagUnset, Flag: flag}, nil
+
+	default:
+		if flag, ok := searchFlagKeys[upper]; ok {
+			return &SearchKey{Op: OpFlagSet, Flag: flag}, nil
+		}
+
+		// Anything else must be a bare sequence set
+		set, err := ParseSeqSet(token)
+		if err != nil {
+			return nil, fmt.Errorf("SEARCH unknown key %q", token)
+		}
+		return &SearchKey{Op: OpSeqSet, SeqSet: set}, nil
+	}
+}
+
+func textOpFor(key string) SearchOp {
+	switch key {
+	case "FROM":
+		return OpFrom
+	case "TO":
+		return OpTo
+	case "CC":
+		return OpCc
+	case "BCC":
+		return OpBcc
+	case "SUBJECT":
+		return OpSubject
+	case "BODY":
+		return OpBody
+	default:
+		return OpText
+	}
+}
+
+func dateOpFor(key string) SearchOp {
+	switch key {
+	case "SINCE":
+		return OpSince
+	case "BEFORE":
+		return OpBefore
+	default:
+		return OpOn
+	}
+}
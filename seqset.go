@@ -0,0 +1,119 @@
+package imapsrv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// seqRange is a single "n" or "n:m" component of a sequence set. A hi of 0
+// represents "*", the largest number in use (message sequence number or
+// UID, depending on context).
+type seqRange struct {
+	lo, hi uint32
+}
+
+// SeqSet is a parsed RFC 3501 §9 sequence-set, e.g. "1:*,3,5:7". It is used
+// both for message sequence numbers and, in UID commands, for UIDs.
+type SeqSet struct {
+	ranges []seqRange
+}
+
+// ParseSeqSet parses a sequence-set string such as "1:*,3,5:7"
+func ParseSeqSet(s string) (*SeqSet, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty sequence set")
+	}
+
+	set := &SeqSet{}
+
+	for _, part := range strings.Split(s, ",") {
+		r, err := parseSeqRange(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sequence set %q: %s", s, err)
+		}
+		set.ranges = append(set.ranges, r)
+	}
+
+	return set, nil
+}
+
+// parseSeqRange parses a single "n", "n:m" or "n:*" component
+func parseSeqRange(part string) (seqRange, error) {
+	bounds := strings.SplitN(part, ":", 2)
+
+	lo, err := parseSeqNumber(bounds[0])
+	if err != nil {
+		return seqRange{}, err
+	}
+
+	if len(bounds) == 1 {
+		return seqRange{lo: lo, hi: lo}, nil
+	}
+
+	hi, err := parseSeqNumber(bounds[1])
+	if err != nil {
+		return seqRange{}, err
+	}
+
+	// RFC 3501 §9 allows either order, e.g. "5:*" and "*:5" are
+	// equivalent, but "*" can't be compared against lo until it's
+	// resolved against max - that happens in Contains.
+	return seqRange{lo: lo, hi: hi}, nil
+}
+
+// parseSeqNumber parses a single sequence number, where "*" is represented
+// as 0 (the caller resolves it against the highest number in use)
+func parseSeqNumber(s string) (uint32, error) {
+	if s == "*" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("sequence numbers are 1-based")
+	}
+
+	return uint32(n), nil
+}
+
+// Contains reports whether n is included in the set. max is substituted for
+// any "*" bound.
+func (set *SeqSet) Contains(n uint32, max uint32) bool {
+	for _, r := range set.ranges {
+		lo, hi := r.lo, r.hi
+		if hi == 0 {
+			hi = max
+		}
+		if lo == 0 {
+			lo = max
+		}
+		if hi < lo {
+			// RFC 3501 §9: ranges may be given in either order
+			lo, hi = hi, lo
+		}
+		if n >= lo && n <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand returns every number in the set, in ascending order with
+// duplicates removed. max is substituted for any "*" bound.
+func (set *SeqSet) Expand(max uint32) []uint32 {
+	seen := make(map[uint32]bool)
+	result := make([]uint32, 0, max)
+
+	for n := uint32(1); n <= max; n++ {
+		if set.Contains(n, max) && !seen[n] {
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,66 @@
+package imapsrv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSeqSet(t *testing.T) {
+	if _, err := ParseSeqSet(""); err == nil {
+		t.Error("expected an error for an empty sequence set")
+	}
+
+	if _, err := ParseSeqSet("0"); err == nil {
+		t.Error("expected an error for a 0 sequence number")
+	}
+
+	if _, err := ParseSeqSet("abc"); err == nil {
+		t.Error("expected an error for a non-numeric sequence number")
+	}
+}
+
+func TestSeqSetExpand(t *testing.T) {
+	cases := []struct {
+		set  string
+		max  uint32
+		want []uint32
+	}{
+		{"1", 10, []uint32{1}},
+		{"1,3,5", 10, []uint32{1, 3, 5}},
+		{"5:7", 10, []uint32{5, 6, 7}},
+		// RFC 3501 §9: a range may be given in either order
+		{"7:5", 10, []uint32{5, 6, 7}},
+		{"5:*", 10, []uint32{5, 6, 7, 8, 9, 10}},
+		{"*:5", 10, []uint32{5, 6, 7, 8, 9, 10}},
+		{"*", 10, []uint32{10}},
+		{"1:3,2:4", 10, []uint32{1, 2, 3, 4}},
+	}
+
+	for _, c := range cases {
+		set, err := ParseSeqSet(c.set)
+		if err != nil {
+			t.Fatalf("ParseSeqSet(%q): unexpected error %s", c.set, err)
+		}
+
+		got := set.Expand(c.max)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseSeqSet(%q).Expand(%d) = %v, want %v", c.set, c.max, got, c.want)
+		}
+	}
+}
+
+func TestSeqSetContains(t *testing.T) {
+	set, err := ParseSeqSet("*:5")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for n := uint32(5); n <= 10; n++ {
+		if !set.Contains(n, 10) {
+			t.Errorf("Contains(%d, 10) = false, want true", n)
+		}
+	}
+	if set.Contains(4, 10) {
+		t.Error("Contains(4, 10) = true, want false")
+	}
+}
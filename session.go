@@ -1,11 +1,22 @@
 package imapsrv
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"log"
-	"regexp"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/RossJHagan/imapsrv/mboxmatch"
 )
 
+// idlePollInterval bounds how long awaitIdleDone blocks on a single read
+// while IDLE is running, so it can notice idle.execute has stopped needing
+// it even when the client never sends another line.
+const idlePollInterval = 1 * time.Second
+
 // IMAP session states
 type state int
 
@@ -25,6 +36,18 @@ type session struct {
 	mailbox *Mailbox
 	// IMAP configuration
 	config *Config
+	// reader/writer talk directly to the client connection. They are used
+	// by commands that need to exchange data with the client mid-command,
+	// such as AUTHENTICATE continuation requests. conn is the same
+	// connection reader/writer wrap; awaitIdleDone uses it to set a read
+	// deadline so it can be cancelled.
+	reader *bufio.Reader
+	writer *bufio.Writer
+	conn   net.Conn
+	// idleDone is non-nil while an IDLE command is in progress. awaitIdleDone
+	// forwards a client "DONE" line here, letting idle.execute break out of
+	// its update-streaming loop.
+	idleDone chan struct{}
 }
 
 // Create a new IMAP session
@@ -35,6 +58,95 @@ func createSession(id int, config *Config) *session {
 		config: config}
 }
 
+// attachIO gives the session direct access to the client connection. It is
+// called once by the connection handler after the session is created.
+func (s *session) attachIO(conn net.Conn, reader *bufio.Reader, writer *bufio.Writer) {
+	s.conn = conn
+	s.reader = reader
+	s.writer = writer
+}
+
+// sendContinuation writes an IMAP continuation request ("+ <challenge>") to
+// the client and blocks for the client's reply line, which is returned with
+// its trailing CRLF stripped.
+func (s *session) sendContinuation(challenge string) (string, error) {
+	if _, err := fmt.Fprintf(s.writer, "+ %s\r\n", challenge); err != nil {
+		return "", err
+	}
+	if err := s.writer.Flush(); err != nil {
+		return "", err
+	}
+
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// writeLine writes a raw line directly to the client connection, adding the
+// trailing CRLF and flushing. It is used by commands that stream untagged
+// responses before returning their final tagged response, such as IDLE.
+func (s *session) writeLine(line string) error {
+	if _, err := fmt.Fprintf(s.writer, "%s\r\n", line); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+// signalIdleDone notifies an in-progress IDLE command that the client has
+// sent DONE. It is a no-op if the session isn't currently idling.
+func (s *session) signalIdleDone() {
+	if s.idleDone == nil {
+		return
+	}
+
+	select {
+	case s.idleDone <- struct{}{}:
+	default:
+	}
+}
+
+// awaitIdleDone reads lines directly off the client connection for the
+// duration of an IDLE command, watching for the bare "DONE" that ends it.
+// idle.execute runs this in the background because it can't both block
+// reading the client's next line and select on the mailbox's Updates
+// channel on the same goroutine.
+//
+// It returns once DONE is seen, the connection errors, or stop is closed.
+// idle.execute closes stop when it returns for any other reason (e.g. the
+// mailbox's Updates channel closed) so this goroutine doesn't outlive it:
+// left running, it would silently swallow the client's next command line
+// instead of leaving it for the dispatch loop, and a subsequent IDLE would
+// race it for the next line off the same reader. Since a read can't be
+// interrupted directly, a short deadline is used to poll stop between
+// reads.
+func (s *session) awaitIdleDone(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		s.conn.SetReadDeadline(time.Now().Add(idlePollInterval))
+
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			s.signalIdleDone()
+			return
+		}
+		if strings.TrimSpace(line) == "DONE" {
+			s.signalIdleDone()
+			return
+		}
+	}
+}
+
 // Log a message with session information
 func (s *session) log(info ...interface{}) {
 	preamble := fmt.Sprintf("IMAP (%d) ", s.id)
@@ -59,63 +171,318 @@ func (s *session) selectMailbox(name string) (bool, error) {
 
 	// Make note of the mailbox
 	s.mailbox = mbox
+
+	// Build the message-number -> UID mapping FETCH/STORE/SEARCH/EXPUNGE
+	// need to translate sequence numbers
+	uids, err := mailstore.AllUids(mbox.Id)
+	if err != nil {
+		return false, err
+	}
+	mbox.SeqToUid = uids
+
 	return true, nil
 }
 
-// List mailboxes matching the given mailbox pattern
-func (s *session) list(reference []string, mbox []string) ([]*Mailbox, error) {
+// writeLiteral writes an IMAP literal ("{n}\r\n<n bytes>") directly to the
+// client connection. It is used for response data too large to buffer as a
+// single response.extra line, such as a FETCH BODY[] message body.
+func (s *session) writeLiteral(data []byte) error {
+	if _, err := fmt.Fprintf(s.writer, "{%d}\r\n", len(data)); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(data); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
 
-	if len(mbox) == 0  {
-		// Lookup mailboxes at the reference point
-		return s.listMailboxes(reference, nil, false)
-	} 
+// writeFetchResponse writes a single untagged "* n FETCH (...)" response for
+// msg, rendering each of attrs in the order given.
+func (s *session) writeFetchResponse(msg *Message, attrs []FetchAttr) error {
+	if _, err := fmt.Fprintf(s.writer, "* %d FETCH (", msg.SeqNum); err != nil {
+		return err
+	}
 
-	// Will this be a recursive listing?
-	recursive := false
-	mboxLastIndex := len(mbox) - 1
-	mboxPattern := mbox[mboxLastIndex]
+	for i, attr := range attrs {
+		if i > 0 {
+			if _, err := s.writer.WriteString(" "); err != nil {
+				return err
+			}
+		}
+		if err := s.writeFetchAttr(msg, attr); err != nil {
+			return err
+		}
+	}
 
-	patLastIndex := len(mboxPattern) - 1
-	if mboxPattern[patLastIndex] == '*' {
-		recursive = true
+	if _, err := s.writer.WriteString(")\r\n"); err != nil {
+		return err
 	}
+	return s.writer.Flush()
+}
+
+// writeFetchAttr writes a single FETCH data item for msg. BODY[]/BODY.PEEK[]
+// sections are streamed as an IMAP literal via writeLiteral, since the raw
+// message bytes may be too large to buffer as part of the surrounding line.
+func (s *session) writeFetchAttr(msg *Message, attr FetchAttr) error {
+	switch attr.Name {
+	case FetchFlags:
+		_, err := fmt.Fprintf(s.writer, "FLAGS (%s)", messageFlagsString(msg.Flags))
+		return err
+
+	case FetchUid:
+		_, err := fmt.Fprintf(s.writer, "UID %d", msg.Uid)
+		return err
+
+	case FetchInternalDate:
+		_, err := fmt.Fprintf(s.writer, `INTERNALDATE "%s"`,
+			msg.InternalDate.Format("02-Jan-2006 15:04:05 -0700"))
+		return err
 
-	// We will build a regular expression to match mailbox names
-	// and a path to search from
-	var mboxRe *regexp.Regexp = nil
-	path := reference
+	case FetchRfc822Size:
+		_, err := fmt.Fprintf(s.writer, "RFC822.SIZE %d", msg.Size)
+		return err
 
-	// Does the mailbox end in a wildcard?
-	if mboxPattern[patLastIndex] == '*' || mboxPattern[patLastIndex] == '%' {
+	case FetchEnvelope:
+		_, err := fmt.Fprintf(s.writer, "ENVELOPE %s", envelopeString(msg.Envelope))
+		return err
 
-		// Build the mailbox path
-		if mboxLastIndex > 0 {
-			path = append(path, mbox[0:mboxLastIndex]...)
+	case FetchBodyStructure:
+		_, err := fmt.Fprintf(s.writer, "BODYSTRUCTURE %s", bodyStructureString(msg.BodyStructure))
+		return err
+
+	case FetchBody:
+		data := selectBodySection(msg.Raw, attr.Section)
+		if attr.Partial {
+			data = partialBytes(data, attr.Offset, attr.Length)
 		}
 
-		// Convert the wildcard into a regular expression
-		var expr string
-		if len(mboxPattern) == 1 {
-			expr = ".*"
-		} else {
-			expr = mboxPattern[0:(patLastIndex-1)] + ".*"
+		// BODY.PEEK[...] is request-only syntax to suppress the \Seen side
+		// effect; the response data item is always BODY[...], even when
+		// attr.Peek is set.
+		label := "BODY[" + attr.Section + "]"
+		if attr.Partial {
+			label += fmt.Sprintf("<%d>", attr.Offset)
 		}
 
-		var err error
-		mboxRe, err = regexp.Compile(expr)
+		if _, err := fmt.Fprintf(s.writer, "%s ", label); err != nil {
+			return err
+		}
+		// msg's flags are left as they are: a BODY[] fetch without .PEEK
+		// does not set \Seen, and the Mailstore is not told of the fetch.
+		return s.writeLiteral(data)
+	}
 
-		if err != nil {
-			return nil, err
+	return nil
+}
+
+// selectBodySection returns the bytes of the requested BODY[] section of an
+// RFC 822 message. Only "", "HEADER" and "TEXT" are recognised; any other
+// section (such as an individual MIME part number) falls back to the whole
+// message, since Message does not model a parsed MIME tree.
+func selectBodySection(raw []byte, section string) []byte {
+	switch strings.ToUpper(section) {
+	case "HEADER":
+		header, _ := splitMessage(raw)
+		return header
+	case "TEXT":
+		_, body := splitMessage(raw)
+		return body
+	default:
+		return raw
+	}
+}
+
+// splitMessage splits an RFC 822 message into its header block (including
+// the trailing blank line) and its body.
+func splitMessage(raw []byte) (header, body []byte) {
+	if idx := bytes.Index(raw, []byte("\r\n\r\n")); idx >= 0 {
+		return raw[:idx+4], raw[idx+4:]
+	}
+	return raw, nil
+}
+
+// partialBytes returns the <offset.length> slice of data requested by a
+// BODY[section]<offset.length> fetch, clamped to data's bounds.
+func partialBytes(data []byte, offset, length int64) []byte {
+	if offset < 0 || offset >= int64(len(data)) {
+		return nil
+	}
+	end := offset + length
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return data[offset:end]
+}
+
+// envelopeString renders the RFC 3501 §7.4.2 ENVELOPE list for e. The
+// address fields are modelled as plain strings rather than parsed address
+// structures, so this is a simplified approximation of the real wire
+// format; a genuine implementation would emit nested address lists.
+func envelopeString(e *Envelope) string {
+	if e == nil {
+		return "NIL"
+	}
+	return fmt.Sprintf("(%s %s %s %s %s %s %s %s %s %s)",
+		quoteOrNil(e.Date), quoteOrNil(e.Subject), quoteOrNil(e.From), quoteOrNil(e.Sender),
+		quoteOrNil(e.ReplyTo), quoteOrNil(e.To), quoteOrNil(e.Cc), quoteOrNil(e.Bcc),
+		quoteOrNil(e.InReplyTo), quoteOrNil(e.MessageId))
+}
+
+// bodyStructureString renders the RFC 3501 §7.4.2 BODYSTRUCTURE list for b.
+// A multipart body is rendered by recursing into Parts followed by its
+// subtype; a single part carries its type, subtype, size and lines, with
+// NIL for the body parameters, id, description and encoding.
+func bodyStructureString(b *BodyStructure) string {
+	if b == nil {
+		return "NIL"
+	}
+
+	if len(b.Parts) > 0 {
+		parts := make([]string, len(b.Parts))
+		for i, p := range b.Parts {
+			parts[i] = bodyStructureString(p)
 		}
+		return fmt.Sprintf(`(%s "%s")`, strings.Join(parts, ""), strings.ToUpper(b.MimeSubtype))
+	}
+
+	return fmt.Sprintf(`("%s" "%s" NIL NIL NIL NIL %d %d)`,
+		strings.ToUpper(b.MimeType), strings.ToUpper(b.MimeSubtype), b.Size, b.Lines)
+}
 
+// quoteOrNil returns s as an IMAP quoted string, or the atom NIL if s is
+// empty
+func quoteOrNil(s string) string {
+	if s == "" {
+		return "NIL"
+	}
+	return `"` + s + `"`
+}
+
+// encodeMailboxName renders a mailbox name for the wire: raw UTF-8 if
+// Config.UTF8Accept is set (RFC 6855), or Modified UTF-7 (RFC 3501 §5.1.3)
+// otherwise. The choice follows the server's Config alone; whether this
+// client has enabled UTF8=ACCEPT is not tracked.
+func (s *session) encodeMailboxName(name string) string {
+	if s.config.UTF8Accept {
+		return name
+	}
+	return EncodeModifiedUTF7(name)
+}
+
+// decodeMailboxName parses a mailbox name received from the client, in
+// whichever encoding encodeMailboxName would have used.
+func (s *session) decodeMailboxName(name string) (string, error) {
+	if s.config.UTF8Accept {
+		return name, nil
+	}
+	return DecodeModifiedUTF7(name)
+}
+
+// List mailboxes matching the given mailbox pattern, relative to reference.
+// reference is as produced by addTrailingDelimiter (possibly ending in the
+// delimiter) and pattern as produced by removeDelimiters (no leading or
+// trailing delimiter); splitPath ignores either way.
+func (s *session) list(reference string, pattern string) ([]*Mailbox, error) {
+
+	refPath := splitPath(reference)
+
+	if pattern == "" {
+		// Lookup mailboxes at the reference point
+		return s.listMailboxes(refPath, nil, false)
+	}
+
+	patPath := splitPath(pattern)
+	lastIndex := len(patPath) - 1
+	lastSegment := patPath[lastIndex]
+
+	// Will this be a recursive listing?
+	recursive := strings.HasSuffix(lastSegment, "*")
+
+	// Build the path to search from
+	path := append(append([]string{}, refPath...), patPath[:lastIndex]...)
+
+	// Does the last segment contain a wildcard? If so match it with a
+	// pattern matcher rather than appending it to the path literally.
+	var matcher *mboxmatch.Matcher
+	if strings.ContainsAny(lastSegment, "*%") {
+		matcher = mboxmatch.New(lastSegment, pathDelimiter)
 	} else {
-		// Build the mailbox path
-		path = append(path, mboxPattern)
+		path = append(path, lastSegment)
 	}
 
 	// Lookup mailboxes at the given path
-	return s.listMailboxes(path, mboxRe, recursive)
+	return s.listMailboxes(path, matcher, recursive)
+}
+
+// splitPath splits a canonical mailbox-name style string on the path
+// delimiter, ignoring any leading/trailing delimiter.
+func splitPath(s string) []string {
+	s = strings.Trim(s, string(pathDelimiter))
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, string(pathDelimiter))
+}
+
+// lastPathSegment returns the final component of a canonical mailbox-name
+// style path, e.g. "Baz" for "Foo/Bar/Baz".
+func lastPathSegment(path string) string {
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return path
+	}
+	return segments[len(segments)-1]
+}
+
+// filterRecursiveMatch implements LIST's RFC 5258 §3.1 RECURSIVEMATCH
+// selection option: a candidate mailbox that matched the pattern is kept
+// if it is itself subscribed, or if it has a subscribed descendant - in
+// which case that descendant is added to the results too, synthesized
+// with the \NonExistent flag if it no longer corresponds to a real
+// mailbox (e.g. its subscription outlived a DELETE).
+func (s *session) filterRecursiveMatch(mboxes []*Mailbox) ([]*Mailbox, error) {
+	mailstore := s.config.Mailstore
+
+	seen := make(map[string]bool, len(mboxes))
+	for _, mbox := range mboxes {
+		seen[mbox.Path] = true
+	}
+
+	result := make([]*Mailbox, 0, len(mboxes))
+
+	for _, mbox := range mboxes {
+		descendants, err := mailstore.SubscribedDescendants(splitPath(mbox.Path))
+		if err != nil {
+			return nil, err
+		}
+
+		if mbox.Flags&Subscribed == 0 && len(descendants) == 0 {
+			continue
+		}
+		if len(descendants) > 0 {
+			mbox.Flags |= HasChildren
+		}
+		result = append(result, mbox)
+
+		for _, path := range descendants {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			desc, err := mailstore.GetMailbox(path)
+			if err != nil {
+				return nil, err
+			}
+			if desc == nil {
+				desc = &Mailbox{Path: path, Name: lastPathSegment(path), Flags: NonExistent}
+			}
+			desc.Flags |= Subscribed
+			result = append(result, desc)
+		}
+	}
 
+	return result, nil
 }
 
 // Add mailbox information to the given response
@@ -148,10 +515,96 @@ func (s *session) addMailboxInfo(resp *response) error {
 	return nil
 }
 
+// statusLine builds the parenthesized attribute list for a STATUS
+// response. Unlike SELECT, it reads mbox's information without selecting
+// it or touching s.mailbox.
+func (s *session) statusLine(mbox *Mailbox, attrs []StatusAttr) (string, error) {
+	mailstore := s.config.Mailstore
+	parts := make([]string, 0, len(attrs))
+
+	for _, attr := range attrs {
+		switch attr {
+		case StatusMessages:
+			n, err := mailstore.TotalMessages(mbox.Id)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("MESSAGES %d", n))
+
+		case StatusRecent:
+			n, err := mailstore.RecentMessages(mbox.Id)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("RECENT %d", n))
+
+		case StatusUidNext:
+			n, err := mailstore.NextUid(mbox.Id)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("UIDNEXT %d", n))
+
+		case StatusUidValidity:
+			parts = append(parts, fmt.Sprintf("UIDVALIDITY %d", mbox.Id))
+
+		case StatusUnseen:
+			n, err := mailstore.FirstUnseen(mbox.Id)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("UNSEEN %d", n))
+		}
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// annotateListResults augments each mailbox's Flags with the subscription,
+// special-use and child information requested via LIST-EXTENDED return
+// options (and LSUB, which always needs subscription state).
+func (s *session) annotateListResults(mboxes []*Mailbox, withChildren, withSubscribed, withSpecialUse bool) error {
+	mailstore := s.config.Mailstore
+
+	for _, mbox := range mboxes {
+		if withSubscribed {
+			subscribed, err := mailstore.IsSubscribed(mbox.Path)
+			if err != nil {
+				return err
+			}
+			if subscribed {
+				mbox.Flags |= Subscribed
+			}
+		}
+
+		if withSpecialUse {
+			use, err := mailstore.SpecialUse(mbox)
+			if err != nil {
+				return err
+			}
+			mbox.Flags |= use
+		}
+
+		if withChildren {
+			children, err := mailstore.GetMailboxes(splitPath(mbox.Path))
+			if err != nil {
+				return err
+			}
+			if len(children) > 0 {
+				mbox.Flags |= HasChildren
+			} else {
+				mbox.Flags |= HasNoChildren
+			}
+		}
+	}
+
+	return nil
+}
+
 // Recursive list mailboxes function.
 func (s *session) listMailboxes(
 	path []string,
-	mboxRe *regexp.Regexp,
+	matcher *mboxmatch.Matcher,
 	recursive bool) ([]*Mailbox, error) {
 
 	log.Print("listMailboxes ", path)
@@ -170,7 +623,7 @@ func (s *session) listMailboxes(
 	for _, mbox := range current {
 
 		// Is there a pattern to match?
-		if mboxRe != nil && !mboxRe.MatchString(mbox.Name) {
+		if matcher != nil && !matcher.Match(mbox.Name) {
 			continue
 		}
 
@@ -0,0 +1,116 @@
+package imapsrv
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// modifiedBase64 is the Base64 variant RFC 3501 §5.1.3 uses inside a
+// Modified UTF-7 shift sequence: '/' is replaced with ',' and there is no
+// '=' padding.
+var modifiedBase64 = base64.NewEncoding(
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,",
+).WithPadding(base64.NoPadding)
+
+// EncodeModifiedUTF7 encodes a UTF-8 mailbox name into the Modified UTF-7
+// representation IMAP uses on the wire for mailbox names (RFC 3501
+// §5.1.3): printable US-ASCII represents itself, "&" is escaped as "&-",
+// and every other run of characters is UTF-16BE encoded, Base64'd with the
+// modified alphabet above, and wrapped in "&" ... "-".
+func EncodeModifiedUTF7(s string) string {
+	var out strings.Builder
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		if r == '&' {
+			out.WriteString("&-")
+			i++
+			continue
+		}
+
+		if r >= 0x20 && r <= 0x7e {
+			out.WriteRune(r)
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(runes) && !(runes[i] >= 0x20 && runes[i] <= 0x7e) {
+			i++
+		}
+
+		out.WriteByte('&')
+		out.WriteString(modifiedBase64.EncodeToString(utf16BEBytes(runes[start:i])))
+		out.WriteByte('-')
+	}
+
+	return out.String()
+}
+
+// DecodeModifiedUTF7 decodes a wire-format mailbox name encoded the way
+// EncodeModifiedUTF7 produces it, back to UTF-8.
+func DecodeModifiedUTF7(s string) (string, error) {
+	var out strings.Builder
+
+	for i := 0; i < len(s); {
+		if s[i] != '&' {
+			out.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		if i+1 < len(s) && s[i+1] == '-' {
+			out.WriteByte('&')
+			i += 2
+			continue
+		}
+
+		end := strings.IndexByte(s[i+1:], '-')
+		if end < 0 {
+			return "", fmt.Errorf("modified UTF-7: unterminated shift sequence in %q", s)
+		}
+
+		decoded, err := decodeUTF7Chunk(s[i+1 : i+1+end])
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(decoded)
+
+		i += 1 + end + 1
+	}
+
+	return out.String(), nil
+}
+
+// utf16BEBytes encodes runes as big-endian UTF-16 code units
+func utf16BEBytes(runes []rune) []byte {
+	units := utf16.Encode(runes)
+	b := make([]byte, 0, len(units)*2)
+	for _, unit := range units {
+		b = append(b, byte(unit>>8), byte(unit))
+	}
+	return b
+}
+
+// decodeUTF7Chunk decodes a single Modified UTF-7 shift sequence's contents
+// (the part between "&" and "-") back to a UTF-8 string
+func decodeUTF7Chunk(encoded string) (string, error) {
+	data, err := modifiedBase64.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("modified UTF-7: invalid base64 %q: %s", encoded, err)
+	}
+	if len(data)%2 != 0 {
+		return "", fmt.Errorf("modified UTF-7: odd number of UTF-16 bytes in %q", encoded)
+	}
+
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+	}
+
+	return string(utf16.Decode(units)), nil
+}
@@ -0,0 +1,64 @@
+package imapsrv
+
+import "testing"
+
+func TestEncodeModifiedUTF7(t *testing.T) {
+	cases := []struct {
+		decoded string
+		encoded string
+	}{
+		{"INBOX", "INBOX"},
+		{"Sent & Received", "Sent &- Received"},
+		{"Übersicht", "&ANw-bersicht"},
+		{"日本語", "&ZeVnLIqe-"},
+	}
+
+	for _, c := range cases {
+		if got := EncodeModifiedUTF7(c.decoded); got != c.encoded {
+			t.Errorf("EncodeModifiedUTF7(%q) = %q, want %q", c.decoded, got, c.encoded)
+		}
+	}
+}
+
+func TestDecodeModifiedUTF7(t *testing.T) {
+	cases := []struct {
+		encoded string
+		decoded string
+	}{
+		{"INBOX", "INBOX"},
+		{"Sent &- Received", "Sent & Received"},
+		{"&ANw-bersicht", "Übersicht"},
+		{"&ZeVnLIqe-", "日本語"},
+	}
+
+	for _, c := range cases {
+		got, err := DecodeModifiedUTF7(c.encoded)
+		if err != nil {
+			t.Fatalf("DecodeModifiedUTF7(%q): unexpected error %s", c.encoded, err)
+		}
+		if got != c.decoded {
+			t.Errorf("DecodeModifiedUTF7(%q) = %q, want %q", c.encoded, got, c.decoded)
+		}
+	}
+}
+
+func TestDecodeModifiedUTF7Unterminated(t *testing.T) {
+	if _, err := DecodeModifiedUTF7("&APw-bersicht&"); err == nil {
+		t.Error("expected an error for an unterminated shift sequence")
+	}
+}
+
+func TestModifiedUTF7RoundTrip(t *testing.T) {
+	names := []string{"INBOX", "Sent & Received", "Übersicht", "日本語", "a&b&c"}
+
+	for _, name := range names {
+		encoded := EncodeModifiedUTF7(name)
+		decoded, err := DecodeModifiedUTF7(encoded)
+		if err != nil {
+			t.Fatalf("round-trip of %q: unexpected error %s", name, err)
+		}
+		if decoded != name {
+			t.Errorf("round-trip of %q = %q", name, decoded)
+		}
+	}
+}